@@ -0,0 +1,319 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultScrapeConfig is the default scrape configuration.
+var DefaultScrapeConfig = ScrapeConfig{
+	Scheme:      "http",
+	MetricsPath: "/metrics",
+}
+
+// DefaultRelabelConfig is the default Relabel configuration.
+var DefaultRelabelConfig = RelabelConfig{
+	Action:      RelabelReplace,
+	Separator:   ";",
+	Regex:       MustNewRegexp("(.*)"),
+	Replacement: "$1",
+}
+
+// RelabelAction is the action to be performed on relabeling.
+type RelabelAction string
+
+const (
+	// RelabelReplace performs a regex replacement.
+	RelabelReplace RelabelAction = "replace"
+	// RelabelKeep drops targets for which the input does not match the regex.
+	RelabelKeep RelabelAction = "keep"
+	// RelabelDrop drops targets for which the input does match the regex.
+	RelabelDrop RelabelAction = "drop"
+	// RelabelKeepEqual drops targets for which the concatenated source labels do
+	// not equal the target label's value.
+	RelabelKeepEqual RelabelAction = "keepequal"
+	// RelabelDropEqual drops targets for which the concatenated source labels
+	// equal the target label's value.
+	RelabelDropEqual RelabelAction = "dropequal"
+	// RelabelLowercase maps the concatenated source labels to their lower case
+	// and writes the result to the target label.
+	RelabelLowercase RelabelAction = "lowercase"
+	// RelabelUppercase maps the concatenated source labels to their upper case
+	// and writes the result to the target label.
+	RelabelUppercase RelabelAction = "uppercase"
+	// RelabelHashMod hashes the concatenated label values and takes a modulus of it.
+	RelabelHashMod RelabelAction = "hashmod"
+	// RelabelLabelMap copies labels to other labelnames based on a regex.
+	RelabelLabelMap RelabelAction = "labelmap"
+	// RelabelLabelDrop drops any label matching the regex.
+	RelabelLabelDrop RelabelAction = "labeldrop"
+	// RelabelLabelKeep drops any label not matching the regex.
+	RelabelLabelKeep RelabelAction = "labelkeep"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (a *RelabelAction) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch act := RelabelAction(strings.ToLower(s)); act {
+	case RelabelReplace, RelabelKeep, RelabelDrop, RelabelKeepEqual, RelabelDropEqual,
+		RelabelLowercase, RelabelUppercase, RelabelHashMod, RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+		*a = act
+		return nil
+	}
+	return fmt.Errorf("unknown relabel action %q", s)
+}
+
+// RelabelConfig is the configuration for relabeling of target label sets.
+type RelabelConfig struct {
+	// A list of labels from which values are taken and concatenated
+	// with the configured separator in order.
+	SourceLabels model.LabelNames `yaml:"source_labels,flow,omitempty"`
+	// Separator is the string between concatenated values from the source labels.
+	Separator string `yaml:"separator,omitempty"`
+	// Regex against which the concatenation is matched.
+	Regex Regexp `yaml:"regex,omitempty"`
+	// Modulus to take of the hash of concatenated values from the source labels.
+	Modulus uint64 `yaml:"modulus,omitempty"`
+	// TargetLabel is the label to which the resulting string is written in a replacement.
+	// Regexp interpolation is allowed for the replace action.
+	TargetLabel string `yaml:"target_label,omitempty"`
+	// Replacement is the regex replacement pattern to be used.
+	Replacement string `yaml:"replacement,omitempty"`
+	// Action is the action to be performed for the relabeling.
+	Action RelabelAction `yaml:"action,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *RelabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRelabelConfig
+	type plain RelabelConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Action == RelabelHashMod && c.Modulus == 0 {
+		return fmt.Errorf("relabel configuration for hashmod requires non-zero modulus")
+	}
+	if c.Action == RelabelLowercase || c.Action == RelabelUppercase ||
+		c.Action == RelabelKeepEqual || c.Action == RelabelDropEqual {
+		if c.Regex != DefaultRelabelConfig.Regex || c.Modulus != DefaultRelabelConfig.Modulus {
+			return fmt.Errorf("%s action requires only 'source_labels' and 'target_label', and no regex or modulus", c.Action)
+		}
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel configuration for %s action requires 'target_label' value", c.Action)
+		}
+		if len(c.SourceLabels) == 0 {
+			return fmt.Errorf("relabel configuration for %s action requires 'source_labels'", c.Action)
+		}
+	}
+	if c.Action == RelabelLabelDrop || c.Action == RelabelLabelKeep {
+		if c.SourceLabels != nil ||
+			c.TargetLabel != DefaultRelabelConfig.TargetLabel ||
+			c.Modulus != DefaultRelabelConfig.Modulus ||
+			c.Separator != DefaultRelabelConfig.Separator ||
+			c.Replacement != DefaultRelabelConfig.Replacement {
+			return fmt.Errorf("%s action requires only 'regex', and no other fields", c.Action)
+		}
+	}
+	return checkOverflow(c.XXX, "relabel_config")
+}
+
+// ScrapeConfig configures a scraping unit for Prometheus.
+type ScrapeConfig struct {
+	// The job name to which the job label is set by default.
+	JobName string `yaml:"job_name"`
+	// How frequently to scrape the targets of this scrape config.
+	ScrapeInterval Duration `yaml:"scrape_interval,omitempty"`
+	// The timeout for scraping targets of this config.
+	ScrapeTimeout Duration `yaml:"scrape_timeout,omitempty"`
+	// The HTTP resource path on which to fetch metrics from targets.
+	MetricsPath string `yaml:"metrics_path,omitempty"`
+	// The URL scheme with which to fetch metrics from targets.
+	Scheme string `yaml:"scheme,omitempty"`
+
+	// The related service discovery configurations for this scrape config.
+	ServiceDiscoveryConfig `yaml:",inline"`
+
+	// List of target relabel configurations.
+	RelabelConfigs []*RelabelConfig `yaml:"relabel_configs,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ScrapeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultScrapeConfig
+	type plain ScrapeConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.JobName == "" {
+		return fmt.Errorf("job_name is empty")
+	}
+	return checkOverflow(c.XXX, "scrape_config")
+}
+
+// ServiceDiscoveryConfig configures lists of different service discovery mechanisms.
+type ServiceDiscoveryConfig struct {
+	// List of DNS service discovery configurations.
+	DNSSDConfigs []*DNSSDConfig `yaml:"dns_sd_configs,omitempty"`
+}
+
+// DNSSDConfig is the configuration for DNS based service discovery.
+type DNSSDConfig struct {
+	Names           []string `yaml:"names"`
+	RefreshInterval Duration `yaml:"refresh_interval,omitempty"`
+	Type            string   `yaml:"type,omitempty"`
+	Port            int      `yaml:"port"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *DNSSDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.RefreshInterval = Duration(30 * time.Second)
+	c.Type = "SRV"
+	type plain DNSSDConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if len(c.Names) == 0 {
+		return fmt.Errorf("DNS-SD config must contain at least one SRV record name")
+	}
+	return checkOverflow(c.XXX, "dns_sd_config")
+}
+
+// TargetGroup is a set of targets discovered by a TargetProvider that share
+// a common set of labels.
+type TargetGroup struct {
+	// Targets is a list of targets identified by a label set. Each target is
+	// uniquely identifiable in the group by its address label.
+	Targets []model.LabelSet
+	// Labels are labels that are common across all targets in the group.
+	Labels model.LabelSet
+	// Source is an identifier that describes a group of targets.
+	Source string
+}
+
+func (tg TargetGroup) String() string {
+	return tg.Source
+}
+
+// CheckTargetAddress checks if target address is valid.
+func CheckTargetAddress(address model.LabelValue) error {
+	if strings.Contains(string(address), "/") {
+		return fmt.Errorf("%q is not a valid hostname", address)
+	}
+	return nil
+}
+
+// Regexp encapsulates a regexp.Regexp and makes it YAML marshalable.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp creates a new anchored Regexp and returns an error if the
+// passed-in regular expression does not compile.
+func NewRegexp(s string) (Regexp, error) {
+	regex, err := regexp.Compile("^(?:" + s + ")$")
+	if err != nil {
+		return Regexp{}, err
+	}
+	return Regexp{
+		Regexp:   regex,
+		original: s,
+	}, nil
+}
+
+// MustNewRegexp works like NewRegexp, but panics if the regular expression does not compile.
+func MustNewRegexp(s string) Regexp {
+	re, err := NewRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	r, err := NewRegexp(s)
+	if err != nil {
+		return err
+	}
+	*re = r
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	if re.original != "" {
+		return re.original, nil
+	}
+	return nil, nil
+}
+
+// Duration wraps time.Duration to support YAML parsing of durations like "30s".
+type Duration time.Duration
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(dur)
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// checkOverflow returns an error if the given map is not empty, naming the
+// fields that were not recognized while decoding into ctx.
+func checkOverflow(m map[string]interface{}, ctx string) error {
+	if len(m) > 0 {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return fmt.Errorf("unknown fields in %s: %s", ctx, strings.Join(keys, ", "))
+	}
+	return nil
+}
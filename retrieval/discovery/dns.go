@@ -0,0 +1,94 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discovery implements service discovery mechanisms that feed
+// target groups to the retrieval package.
+package discovery
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus/prometheus/config"
+)
+
+// DNSDiscovery resolves a fixed set of DNS names into target groups, one
+// group per configured name.
+type DNSDiscovery struct {
+	names []string
+	port  int
+	qtype string
+}
+
+// NewDNSDiscovery returns a DNSDiscovery for the given configuration.
+func NewDNSDiscovery(conf *config.DNSSDConfig) *DNSDiscovery {
+	return &DNSDiscovery{
+		names: conf.Names,
+		port:  conf.Port,
+		qtype: conf.Type,
+	}
+}
+
+// Run resolves the configured names and returns one target group per name.
+// A name that fails to resolve still yields a group, just without targets,
+// so that callers can track its presence across runs.
+func (dd *DNSDiscovery) Run(ctx context.Context) ([]*config.TargetGroup, error) {
+	tgroups := make([]*config.TargetGroup, 0, len(dd.names))
+	for _, name := range dd.names {
+		tg := &config.TargetGroup{Source: name}
+		tgroups = append(tgroups, tg)
+
+		addrs, err := dd.lookup(name)
+		if err != nil {
+			log.Errorf("Error resolving DNS name %q: %s", name, err)
+			continue
+		}
+		for _, addr := range addrs {
+			tg.Targets = append(tg.Targets, model.LabelSet{
+				model.AddressLabel: model.LabelValue(addr),
+			})
+		}
+	}
+	return tgroups, nil
+}
+
+func (dd *DNSDiscovery) lookup(name string) ([]string, error) {
+	switch dd.qtype {
+	case "SRV":
+		_, addrs, err := net.LookupSRV("", "", name)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			res = append(res, net.JoinHostPort(addr.Target, fmt.Sprintf("%d", addr.Port)))
+		}
+		return res, nil
+	case "A", "AAAA":
+		ips, err := net.LookupIP(name)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			res = append(res, net.JoinHostPort(ip.String(), fmt.Sprintf("%d", dd.port)))
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("invalid DNS-SD record type %q", dd.qtype)
+}
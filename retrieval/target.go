@@ -0,0 +1,95 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// Target represents a scrapable target together with its labels and the
+// scrape configuration it was discovered under.
+type Target struct {
+	mtx sync.RWMutex
+	// Labels before any processing.
+	discoveredLabels model.LabelSet
+	// Any labels that are added to this target and its metrics.
+	labels model.LabelSet
+
+	scrapeConfig *config.ScrapeConfig
+
+	cancel context.CancelFunc
+}
+
+// NewTarget creates a reasonably configured target for querying.
+func NewTarget(labels, discoveredLabels model.LabelSet, cfg *config.ScrapeConfig) *Target {
+	return &Target{
+		labels:           labels,
+		discoveredLabels: discoveredLabels,
+		scrapeConfig:     cfg,
+	}
+}
+
+// Labels returns a copy of the target's labels.
+func (t *Target) Labels() model.LabelSet {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return t.labels.Clone()
+}
+
+// fingerprint uniquely identifies a target by the label set it was
+// discovered with, before any relabeling was applied. Targets with the same
+// fingerprint across two discovery runs are considered the same target.
+func (t *Target) fingerprint() model.Fingerprint {
+	return t.discoveredLabels.Fingerprint()
+}
+
+// update refreshes the labels and scrape configuration of a target that was
+// rediscovered with the same identity (see fingerprint) but whose relabeling
+// result or scrape configuration changed, e.g. because relabel_configs were
+// edited. It leaves the target's *Target pointer, and the cancel function
+// recorded by run, untouched.
+func (t *Target) update(labels, discoveredLabels model.LabelSet, cfg *config.ScrapeConfig) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.labels = labels
+	t.discoveredLabels = discoveredLabels
+	t.scrapeConfig = cfg
+}
+
+// run derives a cancelable context from ctx and records its cancel function
+// so that stop can cancel it. It starts no scrape loop and does not use app.
+func (t *Target) run(ctx context.Context, app storage.SampleAppender) {
+	ctx, cancel := context.WithCancel(ctx)
+	t.mtx.Lock()
+	t.cancel = cancel
+	t.mtx.Unlock()
+}
+
+// stop cancels the target's scrape loop, if any. It does not block: there is
+// no scrape loop in this implementation for it to wait on.
+func (t *Target) stop() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retrieval provides the scrape target discovery and scheduling
+// machinery that feeds the storage layer.
+package retrieval
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/relabel"
+	"github.com/prometheus/prometheus/retrieval/discovery"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// TargetProvider discovers the targets for a single scrape configuration and
+// reports them grouped by source.
+type TargetProvider interface {
+	// Run performs a single discovery pass and returns the currently
+	// observed target groups.
+	Run(ctx context.Context) ([]*config.TargetGroup, error)
+}
+
+// providersFromConfig returns all TargetProviders configured in cfg, keyed by
+// a name that is stable across reloads of the same configuration.
+func providersFromConfig(cfg *config.ScrapeConfig) map[string]TargetProvider {
+	providers := map[string]TargetProvider{}
+
+	for i, c := range cfg.DNSSDConfigs {
+		providers[fmt.Sprintf("dns/%d", i)] = discovery.NewDNSDiscovery(c)
+	}
+	return providers
+}
+
+// TargetManager maintains a set of scrape targets for every configured scrape
+// job and keeps them in sync with the jobs' configured service discovery.
+type TargetManager struct {
+	mtx sync.RWMutex
+
+	appender   storage.SampleAppender
+	targetSets map[string]*TargetSet
+}
+
+// NewTargetManager creates a new TargetManager that appends scraped samples
+// to app.
+func NewTargetManager(app storage.SampleAppender) *TargetManager {
+	return &TargetManager{
+		appender:   app,
+		targetSets: map[string]*TargetSet{},
+	}
+}
+
+// ApplyConfig runs the service discovery for every given scrape configuration
+// and reconciles the target sets to match. Jobs that disappear from
+// scrapeConfigs have their targets torn down.
+func (tm *TargetManager) ApplyConfig(scrapeConfigs []*config.ScrapeConfig) {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+
+	jobs := make(map[string]struct{}, len(scrapeConfigs))
+	for _, scfg := range scrapeConfigs {
+		jobs[scfg.JobName] = struct{}{}
+
+		ts, ok := tm.targetSets[scfg.JobName]
+		if !ok {
+			ts = newTargetSet(scfg, tm.appender)
+			tm.targetSets[scfg.JobName] = ts
+		} else {
+			ts.setScrapeConfig(scfg)
+		}
+		ts.runProviders(context.Background(), providersFromConfig(scfg))
+	}
+	// Remove target sets for jobs that no longer exist.
+	for jobName, ts := range tm.targetSets {
+		if _, ok := jobs[jobName]; !ok {
+			ts.stop()
+			delete(tm.targetSets, jobName)
+		}
+	}
+}
+
+// Stop tears down all target sets managed by the TargetManager.
+func (tm *TargetManager) Stop() {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+
+	for _, ts := range tm.targetSets {
+		ts.stop()
+	}
+}
+
+// TargetSet holds the targets belonging to a single scrape configuration,
+// keyed by the target group they were discovered in. Target groups are
+// reconciled incrementally: on each provider run, unchanged groups keep
+// their existing *Target objects so running scrape loops are left alone.
+type TargetSet struct {
+	mtx     sync.RWMutex
+	tgroups map[string][]*Target
+
+	// scrapeConfig is guarded by mtx; read it via currentScrapeConfig and
+	// write it via setScrapeConfig.
+	scrapeConfig *config.ScrapeConfig
+	appender     storage.SampleAppender
+}
+
+// newTargetSet creates a TargetSet for the given scrape configuration. Newly
+// discovered targets have their scraped samples appended to app.
+func newTargetSet(cfg *config.ScrapeConfig, app storage.SampleAppender) *TargetSet {
+	return &TargetSet{
+		tgroups:      map[string][]*Target{},
+		scrapeConfig: cfg,
+		appender:     app,
+	}
+}
+
+// setScrapeConfig atomically replaces the TargetSet's scrape configuration.
+func (ts *TargetSet) setScrapeConfig(cfg *config.ScrapeConfig) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	ts.scrapeConfig = cfg
+}
+
+// currentScrapeConfig returns the scrape configuration last set via
+// setScrapeConfig or newTargetSet.
+func (ts *TargetSet) currentScrapeConfig() *config.ScrapeConfig {
+	ts.mtx.RLock()
+	defer ts.mtx.RUnlock()
+	return ts.scrapeConfig
+}
+
+// runProviders runs every provider once and merges the resulting target
+// groups into the set. A target group keeps its previous *Target objects for
+// any target that was also present in the previous run; only genuinely new
+// targets are created and only genuinely removed targets are torn down.
+// A provider whose run fails, and a group that fails to be processed, report
+// no groups this run, so their previous groups are dropped and torn down too.
+func (ts *TargetSet) runProviders(ctx context.Context, providers map[string]TargetProvider) {
+	newGroups := map[string][]*Target{}
+
+	for name, p := range providers {
+		groups, err := p.Run(ctx)
+		if err != nil {
+			log.Errorf("Error running target provider %s: %s", name, err)
+			continue
+		}
+		for _, tg := range groups {
+			key := name
+			if tg.Source != "" {
+				key = name + "/" + tg.Source
+			}
+			targets, err := ts.targetsFromGroup(tg)
+			if err != nil {
+				log.Errorf("Error processing target group %s: %s", key, err)
+				continue
+			}
+			newGroups[key] = targets
+		}
+	}
+
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	merged := make(map[string][]*Target, len(newGroups))
+	for key, targets := range newGroups {
+		merged[key] = ts.reuseTargets(ts.tgroups[key], targets)
+	}
+	// Tear down groups that disappeared entirely.
+	for key, targets := range ts.tgroups {
+		if _, ok := newGroups[key]; !ok {
+			for _, t := range targets {
+				t.stop()
+			}
+		}
+	}
+	ts.tgroups = merged
+}
+
+// reuseTargets matches old and new targets of the same group by their
+// pre-relabel identity. For every match, the old *Target is kept so its
+// scrape loop and state survive the update, but its labels and scrape
+// configuration are refreshed in place if relabeling produced a different
+// result this run (e.g. because relabel_configs changed). Targets with no
+// match in old are started, and targets with no match in new are stopped.
+//
+// Callers must hold ts.mtx; reuseTargets reads ts.scrapeConfig directly
+// rather than through currentScrapeConfig to avoid re-locking it.
+func (ts *TargetSet) reuseTargets(old, updated []*Target) []*Target {
+	byFingerprint := make(map[model.Fingerprint]*Target, len(old))
+	for _, t := range old {
+		byFingerprint[t.fingerprint()] = t
+	}
+
+	merged := make([]*Target, 0, len(updated))
+	seen := make(map[model.Fingerprint]bool, len(updated))
+	for _, t := range updated {
+		fp := t.fingerprint()
+		seen[fp] = true
+		if prev, ok := byFingerprint[fp]; ok {
+			if !reflect.DeepEqual(prev.Labels(), t.Labels()) || prev.scrapeConfig != ts.scrapeConfig {
+				prev.update(t.Labels(), t.discoveredLabels, ts.scrapeConfig)
+			}
+			merged = append(merged, prev)
+			continue
+		}
+		t.run(context.Background(), ts.appender)
+		merged = append(merged, t)
+	}
+	for _, t := range old {
+		if !seen[t.fingerprint()] {
+			t.stop()
+		}
+	}
+	return merged
+}
+
+// stop tears down every target currently tracked by the set.
+func (ts *TargetSet) stop() {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	for _, targets := range ts.tgroups {
+		for _, t := range targets {
+			t.stop()
+		}
+	}
+}
+
+// targetsFromGroup builds the Targets contained in a discovered target
+// group, applying the scrape config's label population and relabeling.
+func (ts *TargetSet) targetsFromGroup(tg *config.TargetGroup) ([]*Target, error) {
+	cfg := ts.currentScrapeConfig()
+	targets := make([]*Target, 0, len(tg.Targets))
+
+	for i, lset := range tg.Targets {
+		// Combine target labels with the group's common labels.
+		for ln, lv := range tg.Labels {
+			if _, ok := lset[ln]; !ok {
+				lset[ln] = lv
+			}
+		}
+		labels, origLabels, err := populateLabels(lset, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("instance %d in group %s: %s", i, tg, err)
+		}
+		if labels == nil {
+			continue
+		}
+		targets = append(targets, NewTarget(labels, origLabels, cfg))
+	}
+	return targets, nil
+}
+
+// populateLabels builds a label set for the target from the scrape
+// configuration and the target's discovered label set, then applies
+// relabeling. It returns a nil label set if the target is dropped during
+// relabeling, and the original (pre-relabeling) label set for bookkeeping.
+func populateLabels(lset model.LabelSet, cfg *config.ScrapeConfig) (res, orig model.LabelSet, err error) {
+	if _, ok := lset[model.AddressLabel]; !ok {
+		return nil, nil, fmt.Errorf("no address")
+	}
+	// Copy labels into the labelset for the target if they are not
+	// set already. Apply the labelsets in order of decreasing precedence.
+	scrapeLabels := model.LabelSet{
+		model.SchemeLabel:      model.LabelValue(cfg.Scheme),
+		model.MetricsPathLabel: model.LabelValue(cfg.MetricsPath),
+		model.JobLabel:         model.LabelValue(cfg.JobName),
+	}
+	for ln, lv := range scrapeLabels {
+		if _, ok := lset[ln]; !ok {
+			lset[ln] = lv
+		}
+	}
+
+	preRelabelLabels := lset
+	lset = relabel.Process(lset, cfg.RelabelConfigs...)
+
+	// Check if the target was dropped.
+	if lset == nil {
+		return nil, nil, nil
+	}
+
+	// addPort checks whether we should add a default port to the address.
+	addPort := func(s string) bool {
+		// If we can split, a port exists and we don't have to add one.
+		if _, _, err := net.SplitHostPort(s); err == nil {
+			return false
+		}
+		// If adding a port makes it valid, the previous error
+		// was not due to an invalid address and we can append a port.
+		_, _, err := net.SplitHostPort(s + ":1234")
+		return err == nil
+	}
+	// If it's an address with no trailing port, infer it based on the used scheme.
+	if addr := string(lset[model.AddressLabel]); addPort(addr) {
+		switch lset[model.SchemeLabel] {
+		case "http", "":
+			addr = addr + ":80"
+		case "https":
+			addr = addr + ":443"
+		default:
+			return nil, nil, fmt.Errorf("invalid scheme: %q", cfg.Scheme)
+		}
+		lset[model.AddressLabel] = model.LabelValue(addr)
+	}
+	if err := config.CheckTargetAddress(lset[model.AddressLabel]); err != nil {
+		return nil, nil, err
+	}
+
+	// Meta labels are deleted after relabelling. Other internal labels propagate to
+	// the target which decides whether they will be part of their label set.
+	for ln := range lset {
+		if strings.HasPrefix(string(ln), model.MetaLabelPrefix) {
+			delete(lset, ln)
+		}
+	}
+
+	// Default the instance label to the target address.
+	if _, ok := lset[model.InstanceLabel]; !ok {
+		lset[model.InstanceLabel] = lset[model.AddressLabel]
+	}
+	return lset, preRelabelLabels, nil
+}
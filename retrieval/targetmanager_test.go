@@ -25,7 +25,14 @@ import (
 	"github.com/prometheus/prometheus/storage/local"
 )
 
-func TestTargetSetRecreatesTargetGroupsEveryRun(t *testing.T) {
+// TestTargetSetDropsGroupsRemovedFromConfig exercises the end-to-end path
+// through providersFromConfig and real DNS-SD configuration: when a reload
+// stops reporting a DNS name, its group must disappear from tgroups, and the
+// group for a newly added name must appear. It does not by itself exercise
+// pointer reuse (the two configs never share a DNS name, so their groups are
+// never the same group to begin with) — see TestTargetSetReusesUnchangedTargets
+// and TestTargetSetTearsDownRemovedTargets for that.
+func TestTargetSetDropsGroupsRemovedFromConfig(t *testing.T) {
 
 	verifyPresence := func(tgroups map[string][]*Target, name string, present bool) {
 		if _, ok := tgroups[name]; ok != present {
@@ -33,7 +40,7 @@ func TestTargetSetRecreatesTargetGroupsEveryRun(t *testing.T) {
 			if !present {
 				msg = "not "
 			}
-			t.Fatalf("'%s' should %sbe present in TargetSet.tgroups: %s", name, msg, tgroups)
+			t.Fatalf("'%s' should %sbe present in TargetSet.tgroups: %v", name, msg, tgroups)
 		}
 
 	}
@@ -75,6 +82,145 @@ dns_sd_configs:
 	verifyPresence(ts.tgroups, "dns/0/srv.name.two.example.org", true)
 }
 
+// fakeTargetProvider is a TargetProvider whose Run result is fixed ahead of
+// time by the test, so the target groups produced across two runProviders
+// calls can be controlled precisely.
+type fakeTargetProvider struct {
+	tgroups []*config.TargetGroup
+}
+
+func (p *fakeTargetProvider) Run(ctx context.Context) ([]*config.TargetGroup, error) {
+	return p.tgroups, nil
+}
+
+func TestTargetSetReusesUnchangedTargets(t *testing.T) {
+	scrapeConfig := &config.ScrapeConfig{JobName: "foo"}
+	mss := &local.MemorySeriesStorage{}
+	ts := newTargetSet(scrapeConfig, mss)
+
+	provider := &fakeTargetProvider{
+		tgroups: []*config.TargetGroup{
+			{
+				Source: "group",
+				Targets: []model.LabelSet{
+					{model.AddressLabel: "1.2.3.4:1000"},
+					{model.AddressLabel: "1.2.3.5:1000"},
+				},
+			},
+		},
+	}
+	ts.runProviders(context.Background(), map[string]TargetProvider{"fake/0": provider})
+
+	first := ts.tgroups["fake/0/group"]
+	if len(first) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(first))
+	}
+
+	// Re-run with the exact same target group. Unchanged targets must keep
+	// the same *Target pointer so their scrape state is not reset.
+	ts.runProviders(context.Background(), map[string]TargetProvider{"fake/0": provider})
+
+	second := ts.tgroups["fake/0/group"]
+	if len(second) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(second))
+	}
+	for _, want := range first {
+		found := false
+		for _, got := range second {
+			if got == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("target %v was recreated across an unchanged run", want.Labels())
+		}
+	}
+}
+
+func TestTargetSetTearsDownRemovedTargets(t *testing.T) {
+	scrapeConfig := &config.ScrapeConfig{JobName: "foo"}
+	mss := &local.MemorySeriesStorage{}
+	ts := newTargetSet(scrapeConfig, mss)
+
+	provider := &fakeTargetProvider{
+		tgroups: []*config.TargetGroup{
+			{
+				Source: "group",
+				Targets: []model.LabelSet{
+					{model.AddressLabel: "1.2.3.4:1000"},
+					{model.AddressLabel: "1.2.3.5:1000"},
+				},
+			},
+		},
+	}
+	ts.runProviders(context.Background(), map[string]TargetProvider{"fake/0": provider})
+
+	removed := ts.tgroups["fake/0/group"][1]
+
+	provider.tgroups[0].Targets = provider.tgroups[0].Targets[:1]
+	ts.runProviders(context.Background(), map[string]TargetProvider{"fake/0": provider})
+
+	remaining := ts.tgroups["fake/0/group"]
+	if len(remaining) != 1 {
+		t.Fatalf("expected 1 remaining target, got %d", len(remaining))
+	}
+	for _, tgt := range remaining {
+		if tgt == removed {
+			t.Fatalf("removed target %v is still present in the set", tgt.Labels())
+		}
+	}
+	if removed.cancel != nil {
+		t.Fatalf("removed target %v was not stopped", removed.Labels())
+	}
+}
+
+func TestTargetSetUpdatesTargetsOnRelabelConfigChange(t *testing.T) {
+	scrapeConfig := &config.ScrapeConfig{JobName: "foo"}
+	mss := &local.MemorySeriesStorage{}
+	ts := newTargetSet(scrapeConfig, mss)
+
+	provider := &fakeTargetProvider{
+		tgroups: []*config.TargetGroup{
+			{
+				Source: "group",
+				Targets: []model.LabelSet{
+					{model.AddressLabel: "1.2.3.4:1000"},
+				},
+			},
+		},
+	}
+	ts.runProviders(context.Background(), map[string]TargetProvider{"fake/0": provider})
+
+	before := ts.tgroups["fake/0/group"][0]
+	if lv := before.Labels()["env"]; lv != "" {
+		t.Fatalf("expected no 'env' label before relabeling was added, got %q", lv)
+	}
+
+	// Simulate a config reload that adds a relabel rule, as ApplyConfig would
+	// do. SD keeps reporting the exact same raw target.
+	ts.scrapeConfig = &config.ScrapeConfig{
+		JobName: "foo",
+		RelabelConfigs: []*config.RelabelConfig{
+			{
+				Action:      config.RelabelReplace,
+				Regex:       mustNewRegexp(""),
+				Replacement: "prod",
+				TargetLabel: "env",
+			},
+		},
+	}
+	ts.runProviders(context.Background(), map[string]TargetProvider{"fake/0": provider})
+
+	after := ts.tgroups["fake/0/group"][0]
+	if after != before {
+		t.Fatalf("target identity changed across a relabel_configs-only update")
+	}
+	if lv := after.Labels()["env"]; lv != "prod" {
+		t.Fatalf("expected updated target to carry label added by the new relabel_configs, got %q", lv)
+	}
+}
+
 func mustNewRegexp(s string) config.Regexp {
 	re, err := config.NewRegexp(s)
 	if err != nil {
@@ -192,6 +338,194 @@ func TestPopulateLabels(t *testing.T) {
 			res:     nil,
 			resOrig: nil,
 		},
+		// Lowercase a source label into a new target label.
+		{
+			in: model.LabelSet{
+				model.AddressLabel: "1.2.3.4:1000",
+				"custom":           "Value",
+			},
+			cfg: &config.ScrapeConfig{
+				Scheme:      "https",
+				MetricsPath: "/metrics",
+				JobName:     "job",
+				RelabelConfigs: []*config.RelabelConfig{
+					{
+						Action:       config.RelabelLowercase,
+						SourceLabels: model.LabelNames{"custom"},
+						TargetLabel:  "custom_lower",
+					},
+				},
+			},
+			res: model.LabelSet{
+				model.AddressLabel:     "1.2.3.4:1000",
+				model.InstanceLabel:    "1.2.3.4:1000",
+				model.SchemeLabel:      "https",
+				model.MetricsPathLabel: "/metrics",
+				model.JobLabel:         "job",
+				"custom":               "Value",
+				"custom_lower":         "value",
+			},
+			resOrig: model.LabelSet{
+				model.AddressLabel:     "1.2.3.4:1000",
+				model.SchemeLabel:      "https",
+				model.MetricsPathLabel: "/metrics",
+				model.JobLabel:         "job",
+				"custom":               "Value",
+			},
+		},
+		// Uppercase a source label into a new target label.
+		{
+			in: model.LabelSet{
+				model.AddressLabel: "1.2.3.4:1000",
+				"custom":           "Value",
+			},
+			cfg: &config.ScrapeConfig{
+				Scheme:      "https",
+				MetricsPath: "/metrics",
+				JobName:     "job",
+				RelabelConfigs: []*config.RelabelConfig{
+					{
+						Action:       config.RelabelUppercase,
+						SourceLabels: model.LabelNames{"custom"},
+						TargetLabel:  "custom_upper",
+					},
+				},
+			},
+			res: model.LabelSet{
+				model.AddressLabel:     "1.2.3.4:1000",
+				model.InstanceLabel:    "1.2.3.4:1000",
+				model.SchemeLabel:      "https",
+				model.MetricsPathLabel: "/metrics",
+				model.JobLabel:         "job",
+				"custom":               "Value",
+				"custom_upper":         "VALUE",
+			},
+			resOrig: model.LabelSet{
+				model.AddressLabel:     "1.2.3.4:1000",
+				model.SchemeLabel:      "https",
+				model.MetricsPathLabel: "/metrics",
+				model.JobLabel:         "job",
+				"custom":               "Value",
+			},
+		},
+		// Keep a target whose target label equals its source labels.
+		{
+			in: model.LabelSet{
+				model.AddressLabel: "1.2.3.4:1000",
+				"custom":           "value",
+				"custom_copy":      "value",
+			},
+			cfg: &config.ScrapeConfig{
+				Scheme:      "https",
+				MetricsPath: "/metrics",
+				JobName:     "job",
+				RelabelConfigs: []*config.RelabelConfig{
+					{
+						Action:       config.RelabelKeepEqual,
+						SourceLabels: model.LabelNames{"custom"},
+						TargetLabel:  "custom_copy",
+					},
+				},
+			},
+			res: model.LabelSet{
+				model.AddressLabel:     "1.2.3.4:1000",
+				model.InstanceLabel:    "1.2.3.4:1000",
+				model.SchemeLabel:      "https",
+				model.MetricsPathLabel: "/metrics",
+				model.JobLabel:         "job",
+				"custom":               "value",
+				"custom_copy":          "value",
+			},
+			resOrig: model.LabelSet{
+				model.AddressLabel:     "1.2.3.4:1000",
+				model.SchemeLabel:      "https",
+				model.MetricsPathLabel: "/metrics",
+				model.JobLabel:         "job",
+				"custom":               "value",
+				"custom_copy":          "value",
+			},
+		},
+		// Drop a target whose target label does not equal its source labels.
+		{
+			in: model.LabelSet{
+				model.AddressLabel: "1.2.3.4:1000",
+				"custom":           "value",
+				"custom_copy":      "other",
+			},
+			cfg: &config.ScrapeConfig{
+				Scheme:      "https",
+				MetricsPath: "/metrics",
+				JobName:     "job",
+				RelabelConfigs: []*config.RelabelConfig{
+					{
+						Action:       config.RelabelKeepEqual,
+						SourceLabels: model.LabelNames{"custom"},
+						TargetLabel:  "custom_copy",
+					},
+				},
+			},
+			res:     nil,
+			resOrig: nil,
+		},
+		// Drop a target whose target label equals its source labels.
+		{
+			in: model.LabelSet{
+				model.AddressLabel: "1.2.3.4:1000",
+				"custom":           "value",
+				"custom_copy":      "value",
+			},
+			cfg: &config.ScrapeConfig{
+				Scheme:      "https",
+				MetricsPath: "/metrics",
+				JobName:     "job",
+				RelabelConfigs: []*config.RelabelConfig{
+					{
+						Action:       config.RelabelDropEqual,
+						SourceLabels: model.LabelNames{"custom"},
+						TargetLabel:  "custom_copy",
+					},
+				},
+			},
+			res:     nil,
+			resOrig: nil,
+		},
+		// Keep a target whose target label does not equal its source labels.
+		{
+			in: model.LabelSet{
+				model.AddressLabel: "1.2.3.4:1000",
+				"custom":           "value",
+				"custom_copy":      "other",
+			},
+			cfg: &config.ScrapeConfig{
+				Scheme:      "https",
+				MetricsPath: "/metrics",
+				JobName:     "job",
+				RelabelConfigs: []*config.RelabelConfig{
+					{
+						Action:       config.RelabelDropEqual,
+						SourceLabels: model.LabelNames{"custom"},
+						TargetLabel:  "custom_copy",
+					},
+				},
+			},
+			res: model.LabelSet{
+				model.AddressLabel:     "1.2.3.4:1000",
+				model.InstanceLabel:    "1.2.3.4:1000",
+				model.SchemeLabel:      "https",
+				model.MetricsPathLabel: "/metrics",
+				model.JobLabel:         "job",
+				"custom":               "value",
+				"custom_copy":          "other",
+			},
+			resOrig: model.LabelSet{
+				model.AddressLabel:     "1.2.3.4:1000",
+				model.SchemeLabel:      "https",
+				model.MetricsPathLabel: "/metrics",
+				model.JobLabel:         "job",
+				"custom":               "value",
+				"custom_copy":          "other",
+			},
+		},
 	}
 	for i, c := range cases {
 		res, orig, err := populateLabels(c.in, c.cfg)
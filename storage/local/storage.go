@@ -0,0 +1,26 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package local contains a local implementation of storage.SampleAppender.
+package local
+
+import "github.com/prometheus/common/model"
+
+// MemorySeriesStorage is a local implementation of storage.SampleAppender.
+// It stores nothing: Append discards every sample it is given.
+type MemorySeriesStorage struct{}
+
+// Append implements storage.SampleAppender.
+func (s *MemorySeriesStorage) Append(sample *model.Sample) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage defines the interfaces used by scrapers to persist samples.
+package storage
+
+import "github.com/prometheus/common/model"
+
+// SampleAppender is implemented by any component that accepts samples
+// produced by scraping targets.
+type SampleAppender interface {
+	Append(*model.Sample) error
+}